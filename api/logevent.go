@@ -0,0 +1,32 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+// LogEvent is a single record returned to a client by Querier.Query/Follow.
+type LogEvent struct {
+	// Timestamp is the record's timestamp, in unix nanoseconds.
+	Timestamp int64
+
+	// Tags contains the tag line of the source the record was read from.
+	Tags string
+
+	// Message is the raw record message.
+	Message string
+
+	// Fields contains the structured fields parsed from Message (see pkg/fields and
+	// lql.CompileWhere), formatted as strings regardless of their original type. It is empty when
+	// the source has no Parser configured for its tag, or Message failed to parse.
+	Fields map[string]string
+}
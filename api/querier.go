@@ -27,7 +27,14 @@ type (
 		// the LQL line for selecting records
 		Query string
 
-		// Pos contains the next read record position.
+		// Aggregate, when not empty, turns the query into aggregation mode: the server groups
+		// matching records into tumbling windows and returns summary rows via QueryResult.Aggregates
+		// instead of raw Events. See cursor.State.Aggregate for the supported clause syntax.
+		Aggregate string
+
+		// Pos contains the next read record position. In aggregation mode it holds the end of the
+		// last window the server fully summarized, followed by the source positions reached at that
+		// point, so a follow-up query never recomputes that window.
 		Pos string
 
 		// WaitTimeout in seconds provide waiting new data timeout in case of the request starts from
@@ -41,15 +48,62 @@ type (
 
 	// QeryResult is a result returned by the server in a response on LQL execution (see Querier.Query)
 	QueryResult struct {
-		// Events slice contains the result of the query execution
+		// Events slice contains the result of the query execution. Empty whenever the request had an
+		// Aggregate clause - see Aggregates.
 		Events []*LogEvent
+
+		// Aggregates contains the summary rows produced by an aggregation-mode request (see
+		// QueryRequest.Aggregate). It is mutually exclusive with Events: a result carries one or the
+		// other depending on whether the request set Aggregate, never both.
+		Aggregates []*AggregateRow
+
 		// NextQueryRequest contains the query for reading next porition of events. It makes sense only if Err is
 		// nil
 		NextQueryRequest QueryRequest
+		// HeartBeat, when true, indicates that the frame carries no Events and was sent only to let a
+		// Follow() caller detect that the connection is still alive while idle. NextQueryRequest.Pos is
+		// still valid in this case.
+		HeartBeat bool
 		// Err the operation error. If the Err is nil, the operation successfully executed
 		Err error
 	}
 
+	// AggregateRow is one tumbling window's worth of aggregate results, produced by a QueryRequest
+	// with a non-empty Aggregate clause.
+	AggregateRow struct {
+		// From and To bound the window, in unix nanoseconds; [From, To).
+		From, To int64
+
+		// Counts holds the result of every count() in the request's Aggregate clause, keyed by its
+		// clause text (e.g. "count()").
+		Counts map[string]uint64
+
+		// Histograms holds the result of every histogram() in the clause, keyed by its clause text.
+		Histograms map[string][]HistogramBucket
+
+		// TopK holds the result of every topk() in the clause, keyed by its clause text, sorted by
+		// Count descending.
+		TopK map[string][]TopKEntry
+	}
+
+	// HistogramBucket is one bucket of a histogram() aggregate result.
+	HistogramBucket struct {
+		// From and To bound the bucket value range, [From, To).
+		From, To float64
+		// Count is the number of samples which fell in [From, To). The first bucket also counts
+		// samples below the histogram's lower bound, and the last one samples at or above its
+		// upper bound.
+		Count uint64
+	}
+
+	// TopKEntry is one entry of a topk() aggregate result.
+	TopKEntry struct {
+		// Value is the field value, formatted as a string regardless of its underlying type.
+		Value string
+		// Count is the number of records seen with this value in the window.
+		Count uint64
+	}
+
 	// Source struct describes a source structure
 	Source struct {
 		// Tags contains tag for the source
@@ -80,6 +134,21 @@ type (
 		// that the query could not be delivered to the server, or it did not happen.
 		Query(ctx context.Context, req *QueryRequest, res *QueryResult) error
 
+		// Follow opens a long-lived streaming query. Unlike Query, it does not return once the initial
+		// position is exhausted: it keeps the cursor open and pushes a QueryResult to ch every time new
+		// events matching req arrive, plus a periodic HeartBeat frame while idle. req.WaitTimeout is
+		// ignored by Follow, the stream runs until ctx is cancelled or an error occurs.
+		//
+		// Follow honors back-pressure: a send to ch blocks (subject to ctx) rather than buffering
+		// unboundedly, so a slow consumer slows down the server-side read instead of growing memory.
+		// When ctx is cancelled while the stream is idle, Follow makes one non-blocking attempt to
+		// deliver a last QueryResult whose NextQueryRequest.Pos is the last committed position, and
+		// returns nil; that frame is dropped if ch is not ready to receive at that moment. A
+		// cancellation that interrupts a pending send returns ctx.Err() instead. Every frame sent
+		// before that carries NextQueryRequest.Pos too, so the caller can resume with a plain Query
+		// call from the last frame it received.
+		Follow(ctx context.Context, req *QueryRequest, ch chan<- *QueryResult) error
+
 		// Sources requests
 		Sources(ctx context.Context, TagsCond string, res *SourcesResult) error
 	}
@@ -0,0 +1,55 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journald contains a collector which reads records directly from the local
+// systemd-journald and writes them into logrange journals. It is the reverse of journald
+// event logging - here logrange is the consumer, not the emitter.
+package journald
+
+import "time"
+
+type (
+	// Config describes the journald collector settings. It is read from the collector
+	// configuration file the same way as other collector sources.
+	Config struct {
+		// Units restricts the collection to entries with _SYSTEMD_UNIT in this list. An empty
+		// list means no filtering by unit is applied.
+		Units []string
+
+		// Priority is the maximum syslog priority (0=emerg..7=debug) of entries which will be
+		// collected. Entries with a numerically higher PRIORITY value are skipped. nil (the zero
+		// value, so an unconfigured Config applies no filtering) means every priority is
+		// collected; a plain int can't represent this without also claiming the valid priority 0,
+		// which is why this is a pointer - set Priority to a pointer to 0 to collect emerg entries
+		// alone. A negative value disables the filter outright, same as nil.
+		Priority *int
+
+		// BootId restricts the collection to a single boot (_BOOT_ID). An empty value means the
+		// current boot only is used: NewCollector resolves it to the running kernel's boot id, so
+		// callers never silently re-ingest or duplicate entries from prior boots across restarts.
+		// If the current boot id can't be read, no boot filtering is applied.
+		BootId string
+
+		// StateFile is the path where the journald cursor is persisted, so the collector can
+		// resume from the same position after a restart instead of re-reading or loosing entries.
+		StateFile string
+
+		// PollTimeout bounds how long the collector waits for a new journald entry before checking
+		// whether it has been asked to stop.
+		PollTimeout time.Duration
+	}
+)
+
+// DefaultPollTimeout is used when Config.PollTimeout is not set.
+const DefaultPollTimeout = 5 * time.Second
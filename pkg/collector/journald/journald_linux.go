@@ -0,0 +1,325 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package journald
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/sdjournal"
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/logrange/logrange/pkg/tindex"
+	"github.com/logrange/range/pkg/records/journal"
+	"github.com/pkg/errors"
+)
+
+const (
+	fieldUnit  = "_SYSTEMD_UNIT"
+	fieldHost  = "_HOSTNAME"
+	fieldPrio  = "PRIORITY"
+	fieldIdent = "SYSLOG_IDENTIFIER"
+	fieldBoot  = "_BOOT_ID"
+)
+
+// cCursorSaveInterval and cCursorSaveEntries throttle how often Run persists the journald cursor:
+// at most once per interval, or once every cCursorSaveEntries entries, whichever comes first -
+// saving after every single entry (a WriteFile+Rename each) is a real throughput cost on a busy
+// journal. The cursor is always saved once more on shutdown regardless of either bound, so a
+// restart never re-reads or drops more than the last unsaved stretch.
+const cCursorSaveInterval = 5 * time.Second
+const cCursorSaveEntries = 200
+
+type (
+	// Collector reads entries from the local systemd-journald and turns them into logrange
+	// records, writing each one into the journal selected for its tags via tindex.Service.
+	Collector struct {
+		cfg   Config
+		tidx  tindex.Service
+		jctrl journal.Controller
+		jrnl  *sdjournal.Journal
+
+		lastCursorSave   time.Time
+		entriesSinceSave int
+	}
+)
+
+// NewCollector creates a new journald Collector. The returned Collector is not running until
+// Run is called.
+func NewCollector(cfg Config, tidx tindex.Service, jctrl journal.Controller) (*Collector, error) {
+	if cfg.PollTimeout <= 0 {
+		cfg.PollTimeout = DefaultPollTimeout
+	}
+
+	if cfg.BootId == "" {
+		// Fall back to no boot filtering (leaving BootId empty, same as an explicit "don't
+		// filter" request) rather than failing to start when the current boot id can't be
+		// determined - an uncommon environment (no /proc, containerized sandbox, ...) shouldn't
+		// break a collector whose caller never even asked for boot filtering.
+		if bootId, err := currentBootId(); err == nil {
+			cfg.BootId = bootId
+		}
+	}
+
+	jrnl, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open the local systemd journal")
+	}
+
+	c := &Collector{cfg: cfg, tidx: tidx, jctrl: jctrl, jrnl: jrnl, lastCursorSave: time.Now()}
+	if err := c.applyMatches(); err != nil {
+		c.jrnl.Close()
+		return nil, err
+	}
+
+	if err := c.seekStart(); err != nil {
+		c.jrnl.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close releases the underlying journald handle.
+func (c *Collector) Close() error {
+	return c.jrnl.Close()
+}
+
+// Run reads the journal until ctx is cancelled, writing every matching entry into the logrange
+// journal selected by its tags and periodically persisting the journald cursor (see
+// maybeSaveCursor) so a subsequent restart resumes from the same position.
+func (c *Collector) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return c.saveCursor()
+		default:
+		}
+
+		n, err := c.jrnl.Next()
+		if err != nil {
+			return errors.Wrapf(err, "could not read the next journald entry")
+		}
+
+		if n == 0 {
+			c.jrnl.Wait(c.cfg.PollTimeout)
+			continue
+		}
+
+		entry, err := c.jrnl.GetEntry()
+		if err != nil {
+			return errors.Wrapf(err, "could not read the journald entry fields")
+		}
+
+		if err := c.writeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		if err := c.maybeSaveCursor(); err != nil {
+			return err
+		}
+	}
+}
+
+// maybeSaveCursor persists the journald cursor if cCursorSaveInterval has elapsed or
+// cCursorSaveEntries have been written since the last save, throttling the per-entry
+// WriteFile+Rename cost of saveCursor down to a bounded rate on a busy journal.
+func (c *Collector) maybeSaveCursor() error {
+	c.entriesSinceSave++
+	if c.entriesSinceSave < cCursorSaveEntries && time.Since(c.lastCursorSave) < cCursorSaveInterval {
+		return nil
+	}
+
+	if err := c.saveCursor(); err != nil {
+		return err
+	}
+	c.lastCursorSave = time.Now()
+	c.entriesSinceSave = 0
+	return nil
+}
+
+// writeEntry maps a journald entry to a logrange journal record and writes it through the
+// journal.Controller write path.
+func (c *Collector) writeEntry(ctx context.Context, entry *sdjournal.JournalEntry) error {
+	tags := tagsForEntry(entry.Fields)
+	se, err := lql.ParseExpr("{" + tags + "}")
+	if err != nil {
+		return errors.Wrapf(err, "could not build a source expression for tags %s", tags)
+	}
+
+	srcs, _, err := c.tidx.GetJournals(se, 1, true)
+	if err != nil {
+		return errors.Wrapf(err, "could not resolve a journal for tags %s", tags)
+	}
+
+	var src string
+	for _, s := range srcs {
+		src = s
+		break
+	}
+
+	jrnl, err := c.jctrl.GetOrCreate(ctx, src)
+	if err != nil {
+		return errors.Wrapf(err, "could not get or create the journal for tags %s", tags)
+	}
+
+	le := model.NewLogEvent(int64(entry.RealtimeTimestamp)*1000, entry.Fields["MESSAGE"])
+
+	// Attach the structured field map at ingestion time when a Parser is configured for this
+	// source's tags, so a fields.* predicate or aggregate never has to re-parse Message on every
+	// read (pkg/cursor's resolveFields still falls back to on-the-fly parsing for sources with no
+	// configured Parser, e.g. if one is added after old records were already written).
+	if p, ok := fields.DefaultRegistry.ParserFor(tags); ok {
+		if fl, ok := p.Parse(le.Msg()); ok {
+			le.SetFields(fl)
+		}
+	}
+
+	if _, _, err := jrnl.Write(ctx, model.LogEventsToRecords(le)); err != nil {
+		return errors.Wrapf(err, "could not write the journald entry to the journal for tags %s", tags)
+	}
+
+	return nil
+}
+
+// applyMatches configures the journald filter for unit / priority / boot-id so entries which
+// don't match are never read off the journal in the first place.
+func (c *Collector) applyMatches() error {
+	for _, u := range c.cfg.Units {
+		if err := c.jrnl.AddMatch(fieldUnit + "=" + u); err != nil {
+			return errors.Wrapf(err, "could not add a match for unit %s", u)
+		}
+	}
+
+	if c.cfg.Priority != nil && *c.cfg.Priority >= 0 {
+		for p := 0; p <= *c.cfg.Priority; p++ {
+			if err := c.jrnl.AddMatch(fieldPrio + "=" + strconv.Itoa(p)); err != nil {
+				return errors.Wrapf(err, "could not add a match for priority %d", p)
+			}
+		}
+	}
+
+	if c.cfg.BootId != "" {
+		if err := c.jrnl.AddMatch(fieldBoot + "=" + c.cfg.BootId); err != nil {
+			return errors.Wrapf(err, "could not add a match for boot-id %s", c.cfg.BootId)
+		}
+	}
+
+	return nil
+}
+
+// currentBootId returns the running kernel's boot id, formatted the same way journald reports it
+// in the _BOOT_ID field (no dashes), so it can be used directly in an AddMatch call.
+func currentBootId() (string, error) {
+	b, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", errors.Wrap(err, "could not read the current boot id")
+	}
+	return strings.Replace(strings.TrimSpace(string(b)), "-", "", -1), nil
+}
+
+// seekStart positions the journal at the persisted cursor, if any, so the collector resumes
+// without loss or duplication; otherwise it starts from the tail of the journal.
+func (c *Collector) seekStart() error {
+	if c.cfg.StateFile == "" {
+		return c.jrnl.SeekTail()
+	}
+
+	cursor, err := ioutil.ReadFile(c.cfg.StateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.jrnl.SeekTail()
+		}
+		return errors.Wrapf(err, "could not read the journald cursor state file %s", c.cfg.StateFile)
+	}
+
+	if err := c.jrnl.SeekCursor(strings.TrimSpace(string(cursor))); err != nil {
+		return errors.Wrapf(err, "could not seek to the persisted cursor in %s", c.cfg.StateFile)
+	}
+
+	// SeekCursor positions right on the last processed entry, so skip it.
+	_, err = c.jrnl.NextSkip(1)
+	return err
+}
+
+// saveCursor persists the current journald cursor to cfg.StateFile, so a restart resumes from here
+// rather than re-reading or dropping entries. Only the journald cursor is stored; logrange's own
+// journal position is not recorded in the file.
+func (c *Collector) saveCursor() error {
+	if c.cfg.StateFile == "" {
+		return nil
+	}
+
+	cursor, err := c.jrnl.GetCursor()
+	if err != nil {
+		return errors.Wrapf(err, "could not read the current journald cursor")
+	}
+
+	tmp := c.cfg.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(cursor), 0644); err != nil {
+		return errors.Wrapf(err, "could not write the journald cursor state file %s", tmp)
+	}
+
+	return os.Rename(tmp, c.cfg.StateFile)
+}
+
+// tagsForEntry maps the journald fields of interest to a logrange tag line, so cursors created
+// via LQL expressions like {unit=nginx.service} can select this source. Keys are sorted and
+// joined as "k=v,k=v", the same normalized form tindex.Service hands back as the map key from
+// GetJournals (see newCursor and writeEntry, both of which key off that same string) - a
+// different join order or separator here would mean an ingestion-time fields.Parser configured
+// via fields.Registry.Configure(cfg.Tag) is never found on the read path (resolveFields) for
+// these sources.
+func tagsForEntry(fields map[string]string) string {
+	m := map[string]string{}
+	if u, ok := fields[fieldUnit]; ok {
+		m["unit"] = u
+	}
+	if h, ok := fields[fieldHost]; ok {
+		m["host"] = h
+	}
+	if ident, ok := fields[fieldIdent]; ok {
+		m["ident"] = ident
+	}
+	if p, ok := fields[fieldPrio]; ok {
+		m["priority"] = p
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(m[k])
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package journald
+
+import "testing"
+
+func TestTagsForEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   string
+	}{
+		{
+			name:   "all known fields, sorted and joined",
+			fields: map[string]string{fieldUnit: "nginx.service", fieldHost: "web1", fieldIdent: "nginx", fieldPrio: "3"},
+			want:   "host=web1,ident=nginx,priority=3,unit=nginx.service",
+		},
+		{
+			name:   "only unit",
+			fields: map[string]string{fieldUnit: "nginx.service"},
+			want:   "unit=nginx.service",
+		},
+		{
+			name:   "unknown fields are ignored",
+			fields: map[string]string{"_PID": "123", "MESSAGE": "hello"},
+			want:   "",
+		},
+		{
+			name:   "priority maps to the priority tag",
+			fields: map[string]string{fieldPrio: "6"},
+			want:   "priority=6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagsForEntry(tt.fields); got != tt.want {
+				t.Fatalf("tagsForEntry(%v) = %q, want %q", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package journald
+
+import (
+	"context"
+
+	"github.com/logrange/logrange/pkg/tindex"
+	"github.com/logrange/range/pkg/records/journal"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Collector is a no-op stand-in used on platforms where systemd-journald is not available.
+	// NewCollector always fails on these platforms; it exists so callers can be built unconditionally.
+	Collector struct{}
+)
+
+// NewCollector returns an error on non-Linux platforms: systemd-journald is not available there.
+func NewCollector(cfg Config, tidx tindex.Service, jctrl journal.Controller) (*Collector, error) {
+	return nil, errors.New("journald collector is only supported on linux")
+}
+
+// Close is a no-op.
+func (c *Collector) Close() error {
+	return nil
+}
+
+// Run is a no-op, it always returns immediately.
+func (c *Collector) Run(ctx context.Context) error {
+	return nil
+}
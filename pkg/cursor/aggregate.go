@@ -0,0 +1,462 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logrange/logrange/api"
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/pkg/errors"
+)
+
+type (
+	// aggKind identifies which summary an aggFunc computes.
+	aggKind int
+
+	// aggFunc is one parsed aggregation function from a State.Aggregate clause, e.g. count(),
+	// histogram(duration, 10ms..10s) or topk(fields.status, 10).
+	aggFunc struct {
+		kind aggKind
+		// text is the original "func(args)" text; it is used as the key under which the function's
+		// result is reported in api.AggregateRow, so a client can tell which clause a value answers.
+		text string
+		// field is the parsed field path the function reads; unused (nil) for count().
+		field *lql.FieldPath
+		// bucketFrom/bucketTo bound a histogram's buckets; unused for count()/topk().
+		bucketFrom, bucketTo float64
+		// topK is the number of entries topk() keeps; unused otherwise.
+		topK int
+	}
+
+	// aggregator turns a raw model.Iterator event stream into a stream of api.AggregateRow summary
+	// rows, one per completed tumbling window, instead of raw LogEvent(s).
+	aggregator struct {
+		window time.Duration
+		funcs  []aggFunc
+		it     model.Iterator
+		reg    *fields.Registry
+
+		win              *windowAgg
+		lastClosedWindow int64 // unix nanos end of the last window fully emitted
+		resumeBoundary   int64 // windows ending at or before this are skipped, already seen before restart
+	}
+
+	// windowAgg accumulates the per-function state for one open tumbling window.
+	windowAgg struct {
+		from, to int64
+		counts   map[string]uint64
+		hists    map[string]*histState
+		topks    map[string]map[string]uint64
+		funcs    []aggFunc
+	}
+
+	histState struct {
+		from, to float64
+		buckets  []uint64
+	}
+)
+
+const (
+	aggCount aggKind = iota
+	aggHistogram
+	aggTopK
+)
+
+// cHistogramBuckets is the fixed number of equal-width buckets a histogram() function is split
+// into between its from..to bounds.
+const cHistogramBuckets = 10
+
+// ParseAggregate parses a State.Aggregate clause into the tumbling window size and the list of
+// aggregate functions to compute per window. Supported syntax:
+//
+//	count(), histogram(<field>, <from>..<to>), topk(<field>, <k>) GROUP BY tumble(ts, <window>)
+func ParseAggregate(clause string) (time.Duration, []aggFunc, error) {
+	selectPart := clause
+	window := time.Minute
+
+	if idx := strings.Index(strings.ToUpper(clause), "GROUP BY"); idx >= 0 {
+		selectPart = clause[:idx]
+		groupPart := strings.TrimSpace(clause[idx+len("GROUP BY"):])
+
+		const tumblePrefix = "tumble("
+		lp := strings.Index(groupPart, tumblePrefix)
+		rp := strings.LastIndex(groupPart, ")")
+		if lp < 0 || rp < lp {
+			return 0, nil, errors.Errorf("expecting GROUP BY tumble(ts, <window>), got %q", groupPart)
+		}
+		args := splitArgs(groupPart[lp+len(tumblePrefix) : rp])
+		if len(args) != 2 {
+			return 0, nil, errors.Errorf("expecting tumble(ts, <window>), got %q", groupPart)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(args[1]))
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "could not parse tumble window %q", args[1])
+		}
+		if d <= 0 {
+			return 0, nil, errors.Errorf("tumble window must be > 0, got %q", args[1])
+		}
+		window = d
+	}
+
+	var funcs []aggFunc
+	for _, part := range splitArgs(selectPart) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		f, err := parseAggFunc(part)
+		if err != nil {
+			return 0, nil, err
+		}
+		funcs = append(funcs, f)
+	}
+
+	if len(funcs) == 0 {
+		return 0, nil, errors.Errorf("no aggregate functions found in %q", clause)
+	}
+
+	return window, funcs, nil
+}
+
+func parseAggFunc(text string) (aggFunc, error) {
+	lp := strings.Index(text, "(")
+	rp := strings.LastIndex(text, ")")
+	if lp < 0 || rp < lp {
+		return aggFunc{}, errors.Errorf("%q is not a valid aggregate function call", text)
+	}
+
+	name := strings.TrimSpace(text[:lp])
+	args := splitArgs(text[lp+1 : rp])
+
+	// A bare field name such as "duration" refers to the parsed field of that name, the same as
+	// "fields.duration".
+	compileField := func(raw string) (lql.FieldPath, error) {
+		if !strings.HasPrefix(raw, lql.FieldsPrefix) {
+			raw = lql.FieldsPrefix + raw
+		}
+		return lql.CompileFieldPath(raw)
+	}
+
+	switch name {
+	case "count":
+		return aggFunc{kind: aggCount, text: text}, nil
+	case "histogram":
+		if len(args) != 2 {
+			return aggFunc{}, errors.Errorf("histogram() expects (field, from..to), got %q", text)
+		}
+		fp, err := compileField(strings.TrimSpace(args[0]))
+		if err != nil {
+			return aggFunc{}, err
+		}
+		from, to, err := parseRange(strings.TrimSpace(args[1]))
+		if err != nil {
+			return aggFunc{}, err
+		}
+		return aggFunc{kind: aggHistogram, text: text, field: &fp, bucketFrom: from, bucketTo: to}, nil
+	case "topk":
+		if len(args) != 2 {
+			return aggFunc{}, errors.Errorf("topk() expects (field, k), got %q", text)
+		}
+		fp, err := compileField(strings.TrimSpace(args[0]))
+		if err != nil {
+			return aggFunc{}, err
+		}
+		k, err := strconv.Atoi(strings.TrimSpace(args[1]))
+		if err != nil {
+			return aggFunc{}, errors.Wrapf(err, "could not parse topk() k=%q", args[1])
+		}
+		if k <= 0 {
+			return aggFunc{}, errors.Errorf("topk() k must be > 0, got %q", args[1])
+		}
+		return aggFunc{kind: aggTopK, text: text, field: &fp, topK: k}, nil
+	default:
+		return aggFunc{}, errors.Errorf("unknown aggregate function %q", name)
+	}
+}
+
+// parseRange parses a "<from>..<to>" bound, where each side is either a duration (10ms, 10s) or
+// a plain float.
+func parseRange(s string) (float64, float64, error) {
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("expecting <from>..<to>, got %q", s)
+	}
+	from, err := parseNumberOrDuration(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := parseNumberOrDuration(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+func parseNumberOrDuration(s string) (float64, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return float64(d), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// splitArgs splits s on top-level commas, i.e. commas not nested inside parens.
+func splitArgs(s string) []string {
+	var res []string
+	depth := 0
+	last := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	res = append(res, s[last:])
+	return res
+}
+
+// newAggregator parses clause and builds an aggregator consuming the merged event stream it.
+func newAggregator(clause string, it model.Iterator) (*aggregator, error) {
+	window, funcs, err := ParseAggregate(clause)
+	if err != nil {
+		return nil, err
+	}
+	return &aggregator{window: window, funcs: funcs, it: it, reg: fields.DefaultRegistry}, nil
+}
+
+// applyPos resumes from a previously committed window boundary; empty and "head" start from
+// scratch. Aggregation mode cannot resume from "tail".
+func (a *aggregator) applyPos(pos string) error {
+	if pos == "" || strings.EqualFold(pos, "head") {
+		return nil
+	}
+	if strings.EqualFold(pos, "tail") {
+		return errors.New("aggregation mode does not support resuming from the tail")
+	}
+
+	ts, err := strconv.ParseInt(pos, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "could not parse aggregate position %q as a unix nanosecond boundary", pos)
+	}
+	a.resumeBoundary = ts
+	a.lastClosedWindow = ts
+	return nil
+}
+
+// commitPos returns the end of the last window this aggregator fully emitted.
+func (a *aggregator) commitPos() string {
+	return strconv.FormatInt(a.lastClosedWindow, 10)
+}
+
+// Next returns the next completed window's summary row. Once the source is exhausted with no
+// closed window left to report, it finalizes and returns whatever window is still open exactly
+// once (so a bounded Query whose records all land in a single window doesn't come back empty),
+// then returns io.EOF on every call after that, same as model.Iterator.Get. This finalize does
+// not advance lastClosedWindow - the window isn't necessarily complete, just as far as the source
+// currently goes - so a later resume which finds more matching records recomputes and re-reports
+// it rather than skipping it as already seen. Follow never reaches this path: it rejects
+// aggregation mode outright (see Executor.Follow), so there is no resumable consumer here for an
+// unfinished window to mislead.
+func (a *aggregator) Next(ctx context.Context) (*api.AggregateRow, error) {
+	for {
+		le, tags, err := a.it.Get(ctx)
+		if err != nil {
+			if err == io.EOF && a.win != nil {
+				w := a.win
+				a.win = nil
+				if w.to > a.resumeBoundary {
+					return w.finish(), nil
+				}
+			}
+			return nil, err
+		}
+
+		ts := le.Timestamp()
+		end := ts - ts%a.window.Nanoseconds() + a.window.Nanoseconds()
+
+		var closed *api.AggregateRow
+		if a.win == nil {
+			a.win = newWindowAgg(end-a.window.Nanoseconds(), end, a.funcs)
+		} else if ts >= a.win.to {
+			closed = a.win.finish()
+			// The new window always starts at ts's own ts-aligned boundary (end-window), not
+			// the previous window's .to: if the gap since the last event spans more than one
+			// window, a.win.to is stale and would produce a bucket wider than a.window.
+			a.win = newWindowAgg(end-a.window.Nanoseconds(), end, a.funcs)
+		}
+
+		// le always belongs to a.win at this point, whether that is the window just opened above
+		// or the one that was already open.
+		a.win.add(le, tags, a.reg)
+		a.it.Next(ctx)
+
+		if closed != nil {
+			if closed.To <= a.resumeBoundary {
+				// already reported before the last restart
+				continue
+			}
+			a.lastClosedWindow = closed.To
+			return closed, nil
+		}
+	}
+}
+
+func newWindowAgg(from, to int64, funcs []aggFunc) *windowAgg {
+	w := &windowAgg{
+		from:   from,
+		to:     to,
+		counts: make(map[string]uint64),
+		hists:  make(map[string]*histState),
+		topks:  make(map[string]map[string]uint64),
+		funcs:  funcs,
+	}
+	for _, f := range funcs {
+		switch f.kind {
+		case aggHistogram:
+			w.hists[f.text] = &histState{from: f.bucketFrom, to: f.bucketTo, buckets: make([]uint64, cHistogramBuckets)}
+		case aggTopK:
+			w.topks[f.text] = make(map[string]uint64)
+		}
+	}
+	return w
+}
+
+func (w *windowAgg) add(le model.LogEvent, tags model.TagLine, reg *fields.Registry) {
+	// resolveFields returns nil for events whose source has no attached or configured
+	// fields.Parser; histogram()/topk() simply see no sample for those events. Unlike
+	// lql.FieldPredicate's "=" fallback, no raw message match is attempted (see resolveFields in
+	// where.go).
+	fl := resolveFields(reg, le, tags)
+
+	for _, f := range w.funcs {
+		switch f.kind {
+		case aggCount:
+			w.counts[f.text]++
+		case aggHistogram:
+			v, ok := fieldNumber(fl, f.field)
+			if !ok {
+				continue
+			}
+			h := w.hists[f.text]
+			h.buckets[histBucketIndex(v, h)]++
+		case aggTopK:
+			if fl == nil {
+				continue
+			}
+			if v, ok := fl[f.field.Key()]; ok {
+				w.topks[f.text][v.String()]++
+			}
+		}
+	}
+}
+
+// histBucketIndex returns the h.buckets index v falls into, clamping values below h.from into the
+// first bucket and values at or above h.to into the last one, so a sample outside the declared
+// range still lands somewhere rather than indexing out of the fixed-size bucket slice.
+func histBucketIndex(v float64, h *histState) int {
+	bw := (h.to - h.from) / float64(cHistogramBuckets)
+	idx := 0
+	if bw > 0 {
+		idx = int((v - h.from) / bw)
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= cHistogramBuckets {
+		idx = cHistogramBuckets - 1
+	}
+	return idx
+}
+
+// fieldNumber reads fp out of fl as a float64, returning ok=false if fl is nil (unparsed event) or
+// the field is missing or not numeric.
+func fieldNumber(fl fields.Fields, fp *lql.FieldPath) (float64, bool) {
+	if fl == nil {
+		return 0, false
+	}
+	v, ok := fl[fp.Key()]
+	if !ok || v.Kind != fields.KindNumber {
+		return 0, false
+	}
+	return v.Num, true
+}
+
+// finish builds the api.AggregateRow for the window and returns it; the window must not be
+// reused afterwards.
+func (w *windowAgg) finish() *api.AggregateRow {
+	row := &api.AggregateRow{
+		From:   w.from,
+		To:     w.to,
+		Counts: w.counts,
+	}
+
+	for name, h := range w.hists {
+		bw := (h.to - h.from) / float64(cHistogramBuckets)
+		buckets := make([]api.HistogramBucket, cHistogramBuckets)
+		for i, c := range h.buckets {
+			buckets[i] = api.HistogramBucket{From: h.from + float64(i)*bw, To: h.from + float64(i+1)*bw, Count: c}
+		}
+		if row.Histograms == nil {
+			row.Histograms = make(map[string][]api.HistogramBucket)
+		}
+		row.Histograms[name] = buckets
+	}
+
+	for name, counts := range w.topks {
+		entries := make([]api.TopKEntry, 0, len(counts))
+		for v, c := range counts {
+			entries = append(entries, api.TopKEntry{Value: v, Count: c})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+
+		k := len(entries)
+		for _, f := range w.funcs {
+			if f.kind == aggTopK && f.text == name && f.topK < k {
+				k = f.topK
+			}
+		}
+		if row.TopK == nil {
+			row.TopK = make(map[string][]api.TopKEntry)
+		}
+		row.TopK[name] = entries[:k]
+	}
+
+	return row
+}
+
+// NextAggregate advances and returns the next completed aggregate row for a cursor created with a
+// non-empty State.Aggregate. It is the aggregate-mode counterpart of Cursor.Get/Next; calling it
+// on a cursor without an aggregation clause is a programming error.
+func (cur *Cursor) NextAggregate(ctx context.Context) (*api.AggregateRow, error) {
+	if cur.agg == nil {
+		return nil, errors.New("cursor has no aggregation clause")
+	}
+	return cur.agg.Next(ctx)
+}
@@ -0,0 +1,159 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/logrange/logrange/pkg/lql"
+)
+
+func TestParseAggregateDefaultWindow(t *testing.T) {
+	window, funcs, err := ParseAggregate("count()")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if window != time.Minute {
+		t.Fatalf("expected the default 1m window, got %s", window)
+	}
+	if len(funcs) != 1 || funcs[0].kind != aggCount {
+		t.Fatalf("expected a single count() function, got %+v", funcs)
+	}
+}
+
+func TestParseAggregateExplicitWindowAndFuncs(t *testing.T) {
+	window, funcs, err := ParseAggregate(
+		"count(), histogram(duration, 10ms..10s), topk(fields.status, 5) GROUP BY tumble(ts, 30s)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if window != 30*time.Second {
+		t.Fatalf("expected a 30s window, got %s", window)
+	}
+	if len(funcs) != 3 {
+		t.Fatalf("expected 3 functions, got %d", len(funcs))
+	}
+	if funcs[1].kind != aggHistogram || funcs[1].bucketFrom != float64(10*time.Millisecond) || funcs[1].bucketTo != float64(10*time.Second) {
+		t.Fatalf("unexpected histogram bounds: %+v", funcs[1])
+	}
+	if funcs[2].kind != aggTopK || funcs[2].topK != 5 {
+		t.Fatalf("unexpected topk: %+v", funcs[2])
+	}
+}
+
+func TestParseAggregateErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"count() GROUP BY tumble(ts, 0s)",
+		"count() GROUP BY tumble(ts, -1s)",
+		"topk(fields.status, 0)",
+		"topk(fields.status, -1)",
+		"histogram(duration)",
+		"unknown()",
+	}
+
+	for _, clause := range tests {
+		t.Run(clause, func(t *testing.T) {
+			if _, _, err := ParseAggregate(clause); err == nil {
+				t.Fatalf("expected an error for %q", clause)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	from, to, err := parseRange("10ms..10s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if from != float64(10*time.Millisecond) || to != float64(10*time.Second) {
+		t.Fatalf("expected (10ms, 10s) in nanoseconds, got (%v, %v)", from, to)
+	}
+
+	from, to, err = parseRange("0..100")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if from != 0 || to != 100 {
+		t.Fatalf("expected (0, 100), got (%v, %v)", from, to)
+	}
+
+	if _, _, err := parseRange("10"); err == nil {
+		t.Fatal("expected an error for a range with no \"..\"")
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a, b, c", []string{"a", " b", " c"}},
+		{"histogram(a, b), c", []string{"histogram(a, b)", " c"}},
+		{"", []string{""}},
+	}
+
+	for _, tt := range tests {
+		got := splitArgs(tt.in)
+		if len(got) != len(tt.want) {
+			t.Fatalf("splitArgs(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("splitArgs(%q)[%d] = %q, want %q", tt.in, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestWindowAggHistogramBucketEdgesAndClamping(t *testing.T) {
+	fp, err := lql.CompileFieldPath("fields.duration")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	funcs := []aggFunc{{kind: aggHistogram, text: "histogram(fields.duration, 0..100)", field: &fp, bucketFrom: 0, bucketTo: 100}}
+	w := newWindowAgg(0, int64(time.Minute), funcs)
+
+	// below range, at the lower edge, mid-range, at the upper edge and above range - all must
+	// land in a valid bucket rather than indexing out of the fixed-size bucket slice. Driven
+	// through the same histBucketIndex w.add() itself calls, not a copy of its arithmetic.
+	values := []float64{-10, 0, 55, 100, 1000}
+	h := w.hists[funcs[0].text]
+	for _, v := range values {
+		h.buckets[histBucketIndex(v, h)]++
+	}
+
+	row := w.finish()
+	buckets := row.Histograms[funcs[0].text]
+	if len(buckets) != cHistogramBuckets {
+		t.Fatalf("expected %d buckets, got %d", cHistogramBuckets, len(buckets))
+	}
+
+	var total uint64
+	for _, b := range buckets {
+		total += b.Count
+	}
+	if total != uint64(len(values)) {
+		t.Fatalf("expected every sample to land in some bucket, got total=%d", total)
+	}
+	if buckets[0].Count == 0 {
+		t.Fatal("expected the below-range and lower-edge samples to clamp into the first bucket")
+	}
+	if buckets[cHistogramBuckets-1].Count == 0 {
+		t.Fatal("expected the at/above-range samples to clamp into the last bucket")
+	}
+}
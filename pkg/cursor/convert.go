@@ -0,0 +1,37 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"github.com/logrange/logrange/api"
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/logrange/logrange/pkg/model"
+)
+
+// toAPILogEvent converts a record read off a Cursor into the wire-level type returned to clients
+// by Querier.Query/Follow, carrying along whatever structured fields were parsed for it (see
+// whereFilterIterator) so a client never has to re-parse Message itself. It goes through
+// resolveFields rather than le.Fields() directly, so a record from a source with no configured
+// ingestion-time parser still gets its fields populated here, the same fallback the Where filter
+// already gets - otherwise such a record would correctly match a fields.* predicate but ship back
+// to the client with an empty Fields map.
+func toAPILogEvent(le model.LogEvent, tags model.TagLine) *api.LogEvent {
+	return &api.LogEvent{
+		Timestamp: le.Timestamp(),
+		Tags:      string(tags),
+		Message:   string(le.Msg()),
+		Fields:    resolveFields(fields.DefaultRegistry, le, tags).ToStringMap(),
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/logrange/logrange/pkg/fields"
 	"github.com/logrange/logrange/pkg/lql"
 	"github.com/logrange/logrange/pkg/model"
 	"github.com/logrange/logrange/pkg/tindex"
@@ -27,21 +28,46 @@ import (
 )
 
 type (
+	// Direction selects which way a Cursor walks records relative to its Pos.
+	Direction int
+
 	// State describes state of a cursor. This structure is used for creating new ones and
 	// for providing current state of a cursor
 	State struct {
 		// Id the cursor state Id
 		Id uint64
 
+		// Direction selects whether the cursor walks towards later (Forward) or earlier (Backward)
+		// records from Pos. On a single-source cursor it may be flipped via ApplyState without
+		// otherwise touching Sources, Where or Id - Pos stays a plain journal position either way,
+		// so paging backwards from wherever a forward read left off (or vice versa) just works. A
+		// multi-source cursor rejects the flip instead: its merge tree picks earliest-or-latest
+		// based on the Direction at creation time and can't be re-picked after the fact, so
+		// ApplyState refuses rather than silently interleaving records out of order.
+		Direction Direction
+
 		// Sources contains tags expressions for selecting journal sources.
 		Sources string
 
-		// Where contains the expression over LogEvent fields (ts and msg) to filter them. Empty value
-		// indicates all records
+		// Where contains the expression over LogEvent fields (ts and msg) to filter them, e.g.
+		// "ts > 0 AND msg CONTAINS \"panic\"". Empty value indicates all records. It may also
+		// reference parsed structured fields, e.g. "fields.status >= 500", compiled via
+		// lql.CompileFieldPredicate; for records whose source has no fields.Parser configured, an
+		// "=" predicate falls back to a raw message match and any other operator does not match. The whole clause is compiled to a single
+		// lql.WherePredicate by lql.CompileWhere - there is no separate ts/msg evaluator.
 		Where string
 
-		// Pos indicates the position of the record which must be read next.
+		// Pos indicates the position of the record which must be read next. When Aggregate is not
+		// empty, Pos instead encodes the end of the last tumbling window completed by the
+		// aggregation, so a follow-up query resumes without recomputing closed windows.
 		Pos string
+
+		// Aggregate, when not empty, turns the cursor into aggregation mode: instead of raw
+		// LogEvent(s), NextAggregate surfaces summary rows computed by the functions and tumbling
+		// window described by the clause, e.g. "count(), histogram(duration, 10ms..10s),
+		// topk(fields.status, 10) GROUP BY tumble(ts, 1m)"; Get/Next are not used in this mode. See
+		// ParseAggregate for the supported syntax.
+		Aggregate string
 	}
 
 	// Cursor struct describes a context of a query execution. Cursor state could be expressed in cursor.State and
@@ -51,6 +77,7 @@ type (
 		state  State
 		it     model.Iterator
 		jDescs map[string]*jrnlDesc
+		agg    *aggregator
 	}
 
 	jrnlDesc struct {
@@ -62,6 +89,14 @@ type (
 
 const cMaxSources = 50
 
+const (
+	// Forward walks records from Pos towards later ones. It is the zero value, so an unset
+	// Direction behaves as it always did before Direction existed.
+	Forward Direction = iota
+	// Backward walks records from Pos towards earlier ones.
+	Backward
+)
+
 // newCursor creates the new cursor based on the state provided.
 func newCursor(ctx context.Context, state State, tidx tindex.Service, jctrl journal.Controller) (*Cursor, error) {
 	// figuring out the journals list
@@ -83,6 +118,8 @@ func newCursor(ctx context.Context, state State, tidx tindex.Service, jctrl jour
 		return nil, errors.Errorf("too many sources (greater than %d) correspond to the expresion \"%s\", more concreate condition is needed to reduce the number. ", cMaxSources, state.Sources)
 	}
 
+	backward := state.Direction == Backward
+
 	jd := make(map[string]*jrnlDesc, len(srcs))
 	// create the iterators
 	var it model.Iterator
@@ -93,6 +130,7 @@ func newCursor(ctx context.Context, state State, tidx tindex.Service, jctrl jour
 				return nil, errors.Wrapf(err, "Could not get the access to the journal %s for tags %s, which's got for the \"%s\" expression ", src, tags, state.Sources)
 			}
 			jit := jrnl.Iterator()
+			jit.SetBackward(backward)
 			it = (&model.LogEventIterator{}).Wrap(tags, jit)
 
 			jd[src] = &jrnlDesc{tags, jrnl, jit}
@@ -108,16 +146,23 @@ func newCursor(ctx context.Context, state State, tidx tindex.Service, jctrl jour
 			}
 
 			jit := jrnl.Iterator()
+			jit.SetBackward(backward)
 			jd[src] = &jrnlDesc{tags, jrnl, jit}
 			mxs[i] = (&model.LogEventIterator{}).Wrap(tags, jit)
 			i++
 		}
 
-		// mixing them
+		// mixing them; a backward cursor walks from later records to earlier ones, so the merge
+		// tree must pick the latest of each pair instead of the earliest.
+		pick := model.GetEarliest
+		if backward {
+			pick = model.GetLatest
+		}
+
 		for len(mxs) > 1 {
 			for i := 0; i < len(mxs)-1; i += 2 {
 				m := &model.Mixer{}
-				m.Init(model.GetEarliest, mxs[i], mxs[i+1])
+				m.Init(pick, mxs[i], mxs[i+1])
 				mxs[i/2] = m
 			}
 			if len(mxs)&1 == 1 {
@@ -131,10 +176,25 @@ func newCursor(ctx context.Context, state State, tidx tindex.Service, jctrl jour
 		it = mxs[0]
 	}
 
+	wherePred, err := lql.CompileWhere(state.Where)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not compile the where clause \"%s\" ", state.Where)
+	}
+	it = newWhereFilterIterator(it, wherePred, fields.DefaultRegistry)
+
 	cur := new(Cursor)
 	cur.state = state
 	cur.it = it
 	cur.jDescs = jd
+
+	if state.Aggregate != "" {
+		agg, err := newAggregator(state.Aggregate, it)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not parse the aggregation clause \"%s\"", state.Aggregate)
+		}
+		cur.agg = agg
+	}
+
 	if err := cur.applyPos(); err != nil {
 		return nil, errors.Wrapf(err, "The position %s could not be applied ", state.Pos)
 	}
@@ -165,14 +225,39 @@ func (cur *Cursor) Get(ctx context.Context) (model.LogEvent, model.TagLine, erro
 // ApplyState tries to apply state to the cursor. Returns an error, if the operation could not be completed.
 // Current implementation allows to apply position only
 func (cur *Cursor) ApplyState(state State) error {
-	if cur.state.Where != state.Where || cur.state.Sources != state.Sources || cur.state.Id != state.Id {
+	if cur.state.Where != state.Where || cur.state.Sources != state.Sources || cur.state.Id != state.Id || cur.state.Aggregate != state.Aggregate {
 		return errors.Errorf("Could not apply state %s to the current cursor state %s", state, cur.state)
 	}
 
+	// Direction may be flipped on a single-source cursor: it walks the very same (Sources, Where,
+	// Id) position in the other direction, it doesn't identify a different cursor the way
+	// Sources/Where/Id do. A multi-source cursor can't support this: its merge tree is built once
+	// at newCursor time with a pick function fixed to the cursor's creation-time Direction
+	// (GetEarliest for Forward, GetLatest for Backward), and there is no way to flip that pick
+	// function after the fact - flipping SetBackward on the per-journal iterators alone would
+	// leave the merge tree interleaving descending children with an ascending pick, corrupting
+	// the order. Rather than silently produce out-of-order results, reject the flip outright; a
+	// caller needing to walk backwards from here must open a new cursor instead.
+	if cur.state.Direction != state.Direction {
+		if len(cur.jDescs) > 1 {
+			return errors.Errorf("cannot flip Direction on a cursor with %d sources; open a new cursor to walk Direction %d instead", len(cur.jDescs), state.Direction)
+		}
+		cur.state.Direction = state.Direction
+		backward := state.Direction == Backward
+		for _, jd := range cur.jDescs {
+			jd.it.SetBackward(backward)
+		}
+	}
+
 	if cur.state.Pos != state.Pos {
 		oldPos := cur.state.Pos
 		cur.state.Pos = state.Pos
-		err := cur.applyStatePos()
+		var err error
+		if cur.agg != nil {
+			err = cur.applyAggPos()
+		} else {
+			err = cur.applyStatePos(cur.state.Pos)
+		}
 		if err != nil {
 			cur.state.Pos = oldPos
 			return errors.Wrapf(err, "Could not apply position %s to the cursor state %s ", state.Pos, cur.state)
@@ -184,8 +269,22 @@ func (cur *Cursor) ApplyState(state State) error {
 const cPosJrnlSplit = ":"
 const cPosJrnlVal = "="
 
+// cPosAggSplit separates an aggregate Pos into the completed-window boundary (see
+// aggregator.commitPos) and the per-journal positions the source iterators had reached at that
+// point (same format collectPos/applyStatePos use for a raw cursor), so a resume can seek
+// straight there instead of re-scanning the journal from head to rediscover it.
+const cPosAggSplit = "|"
+
 // Commit is called by the cursor reader to indicate that the reading process is over and return the current state
 func (cur *Cursor) commit(ctx context.Context) State {
+	if cur.agg != nil {
+		// The per-journal positions are persisted alongside the window boundary so a resumed
+		// query can seek straight to them instead of re-reading (and re-aggregating) everything
+		// from head just to reach the same point.
+		cur.state.Pos = cur.agg.commitPos() + cPosAggSplit + cur.collectPos()
+		return cur.state
+	}
+
 	// calling cur.Get(ctx) to fix the cursor position in case of last call was cur.Next()
 	cur.Get(ctx)
 	cur.state.Pos = cur.collectPos()
@@ -212,8 +311,12 @@ func (cur *Cursor) collectPos() string {
 }
 
 func (cur *Cursor) applyPos() error {
+	if cur.agg != nil {
+		return cur.applyAggPos()
+	}
+
 	if !cur.applyCornerPos(cur.state.Pos) {
-		err := cur.applyStatePos()
+		err := cur.applyStatePos(cur.state.Pos)
 		if err != nil {
 			return err
 		}
@@ -221,13 +324,56 @@ func (cur *Cursor) applyPos() error {
 	return nil
 }
 
+// applyAggPos splits an aggregate Pos into its window boundary and per-journal parts (see
+// commit), applies the boundary to the aggregator so already-reported windows stay suppressed,
+// and seeks the source iterators straight to the per-journal positions - without this, a resume
+// would start the iterators at head and re-read (and re-aggregate) every record up to the
+// boundary just to throw the recomputed windows away.
+func (cur *Cursor) applyAggPos() error {
+	boundary, jrnlPos := splitAggPos(cur.state.Pos)
+	if err := cur.agg.applyPos(boundary); err != nil {
+		return err
+	}
+	if jrnlPos == "" {
+		// A fresh start ("", "head") or a "tail" resume (rejected by aggregator.applyPos) has no
+		// per-journal positions to seek to; the iterators are left at their newCursor default.
+		return nil
+	}
+	return cur.applyStatePos(jrnlPos)
+}
+
+// splitAggPos splits an aggregate Pos produced by commit into its window-boundary and
+// per-journal-positions parts. A Pos with no cPosAggSplit (e.g. "", "head", "tail", or one typed
+// in by a caller rather than round-tripped through commit) is treated as boundary-only.
+func splitAggPos(pos string) (boundary, jrnlPos string) {
+	idx := strings.Index(pos, cPosAggSplit)
+	if idx < 0 {
+		return pos, ""
+	}
+	return pos[:idx], pos[idx+len(cPosAggSplit):]
+}
+
+// applyCornerPos handles the special "head"/"tail"/"" positions. "head" always means the earliest
+// possible position and "tail" always means the latest one, the same for a Forward or a Backward
+// cursor - Direction only changes which way Next walks from there, not what the corner names mean.
+// The empty position is the one case that is direction-sensitive: it resolves to each direction's
+// natural starting corner, head for Forward and tail for Backward, so a caller doesn't need to
+// know the corner name just to start reading.
 func (cur *Cursor) applyCornerPos(pstr string) bool {
 	ps := strings.ToLower(pstr)
+	if ps == "" {
+		if cur.state.Direction == Backward {
+			ps = "tail"
+		} else {
+			ps = "head"
+		}
+	}
+
 	var p journal.Pos
 	if ps == "tail" {
 		p.CId = 0xFFFFFFFFFFFFFFFF
 		p.Idx = 0xFFFFFFFF
-	} else if ps != "head" && ps != "" {
+	} else if ps != "head" {
 		return false
 	}
 
@@ -237,15 +383,19 @@ func (cur *Cursor) applyCornerPos(pstr string) bool {
 	return true
 }
 
-func (cur *Cursor) applyStatePos() error {
-	vals := strings.Split(cur.state.Pos, cPosJrnlSplit)
+// applyStatePos parses rawPos as the raw per-journal position format collectPos produces
+// (<jrnlId>=<jrnlPos>[:<jrnlId>=<jrnlPos>]...) and seeks each known journal's iterator there.
+// rawPos is passed in rather than read off cur.state directly since an aggregate cursor applies
+// it out of the per-journal part of its combined Pos (see applyAggPos), not cur.state.Pos itself.
+func (cur *Cursor) applyStatePos(rawPos string) error {
+	vals := strings.Split(rawPos, cPosJrnlSplit)
 	m := make(map[string]journal.Pos, len(vals))
 	for _, v := range vals {
 		kv := strings.Split(v, cPosJrnlVal)
 		if len(kv) != 2 {
 			return errors.Errorf(
 				"Could not parse position=%s, value the %s sub-string doesn't look like journal pos. Expecting <jrnlId>%s<jrnlPos>",
-				cur.state.Pos, v, cPosJrnlVal)
+				rawPos, v, cPosJrnlVal)
 		}
 
 		jrnl := kv[0]
@@ -265,5 +415,5 @@ func (cur *Cursor) applyStatePos() error {
 }
 
 func (s State) String() string {
-	return fmt.Sprintf("{Id: %d, Sources:\"%s\", Where:\"%s\", Pos:%s}", s.Id, s.Sources, s.Where, s.Pos)
+	return fmt.Sprintf("{Id: %d, Sources:\"%s\", Where:\"%s\", Aggregate:\"%s\", Direction:%d, Pos:%s}", s.Id, s.Sources, s.Where, s.Aggregate, s.Direction, s.Pos)
 }
\ No newline at end of file
@@ -0,0 +1,81 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"testing"
+
+	"github.com/logrange/range/pkg/records/journal"
+)
+
+// fakeJournalIterator implements the journal.Iterator methods this package actually calls
+// (SetBackward/SetPos/Pos/Release - see jrnlDesc.it's use in cursor.go) so ApplyState's
+// Direction-flip wiring can be exercised without a real journal.Journal/journal.Controller, which
+// this trimmed tree doesn't vendor. It does not attempt to cover the full journal.Iterator
+// surface (e.g. whatever model.LogEventIterator.Wrap itself reads off it to produce raw records) -
+// only what cursor.go touches directly.
+type fakeJournalIterator struct {
+	pos       journal.Pos
+	backward  bool
+	setBwCall int
+}
+
+func (f *fakeJournalIterator) SetBackward(bkwd bool) {
+	f.backward = bkwd
+	f.setBwCall++
+}
+
+func (f *fakeJournalIterator) SetPos(pos journal.Pos) { f.pos = pos }
+
+func (f *fakeJournalIterator) Pos() journal.Pos { return f.pos }
+
+func (f *fakeJournalIterator) Release() {}
+
+func TestApplyStateFlipsDirectionOnSingleSource(t *testing.T) {
+	fj := &fakeJournalIterator{}
+	cur := &Cursor{
+		state:  State{Id: 1, Sources: "a", Direction: Forward, Pos: "head"},
+		jDescs: map[string]*jrnlDesc{"a": {it: fj}},
+	}
+
+	err := cur.ApplyState(State{Id: 1, Sources: "a", Direction: Backward, Pos: "head"})
+	if err != nil {
+		t.Fatalf("unexpected error flipping Direction on a single-source cursor: %v", err)
+	}
+	if cur.state.Direction != Backward {
+		t.Fatalf("expected Direction to flip to Backward, got %v", cur.state.Direction)
+	}
+	if fj.setBwCall != 1 || !fj.backward {
+		t.Fatalf("expected the source iterator to be told to walk backward, got calls=%d backward=%v", fj.setBwCall, fj.backward)
+	}
+}
+
+func TestApplyStateRejectsDirectionFlipOnMultiSource(t *testing.T) {
+	cur := &Cursor{
+		state: State{Id: 1, Sources: "a or b", Direction: Forward},
+		jDescs: map[string]*jrnlDesc{
+			"a": {},
+			"b": {},
+		},
+	}
+
+	err := cur.ApplyState(State{Id: 1, Sources: "a or b", Direction: Backward})
+	if err == nil {
+		t.Fatal("expected an error flipping Direction on a multi-source cursor")
+	}
+	if cur.state.Direction != Forward {
+		t.Fatalf("expected Direction to stay Forward after a rejected flip, got %v", cur.state.Direction)
+	}
+}
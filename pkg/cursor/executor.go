@@ -0,0 +1,163 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"github.com/logrange/logrange/api"
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/tindex"
+	"github.com/logrange/range/pkg/records/journal"
+	"github.com/pkg/errors"
+)
+
+// Executor is the server-side implementation of api.Querier: it turns a QueryRequest into a
+// cursor.State, runs a short-lived Cursor over it for Query, or keeps one open and streams
+// results via Cursor.Follow for Follow. It is the concrete implementation the query path was
+// missing: without it api.Querier.Follow had no caller, and Cursor.Follow had nothing driving it
+// from a request.
+//
+// Executor only implements the query-execution logic; wiring it up to an actual network
+// transport (gRPC/HTTP handlers) is the responsibility of whatever package owns the server's
+// listener, same as it would be for any other api.Querier implementation.
+type Executor struct {
+	tidx  tindex.Service
+	jctrl journal.Controller
+}
+
+// NewExecutor creates an Executor which resolves sources via tidx and reads journals via jctrl.
+func NewExecutor(tidx tindex.Service, jctrl journal.Controller) *Executor {
+	return &Executor{tidx: tidx, jctrl: jctrl}
+}
+
+var _ api.Querier = (*Executor)(nil)
+
+// Query implements api.Querier.
+func (e *Executor) Query(ctx context.Context, req *api.QueryRequest, res *api.QueryResult) error {
+	state, err := stateFromRequest(req)
+	if err != nil {
+		res.Err = err
+		return nil
+	}
+
+	cur, err := newCursor(ctx, state, e.tidx, e.jctrl)
+	if err != nil {
+		res.Err = err
+		return nil
+	}
+
+	limit := req.Limit
+	if state.Aggregate != "" {
+		for limit <= 0 || len(res.Aggregates) < limit {
+			row, aerr := cur.NextAggregate(ctx)
+			if aerr != nil {
+				if aerr != io.EOF {
+					res.Err = aerr
+				}
+				break
+			}
+			res.Aggregates = append(res.Aggregates, row)
+		}
+	} else {
+		for limit <= 0 || len(res.Events) < limit {
+			le, tags, gerr := cur.Get(ctx)
+			if gerr != nil {
+				break
+			}
+			res.Events = append(res.Events, toAPILogEvent(le, tags))
+			cur.Next(ctx)
+		}
+	}
+
+	final := cur.commit(ctx)
+	res.NextQueryRequest = *req
+	res.NextQueryRequest.Pos = final.Pos
+	return nil
+}
+
+// Follow implements api.Querier. It opens a cursor over req and streams results to ch until ctx
+// is cancelled or an unrecoverable error occurs - see Cursor.Follow for the batching and
+// back-pressure behavior.
+//
+// Follow does not support aggregation mode: Cursor.Follow only ever reads and batches raw events,
+// it has no aggregate-aware path, so a req with a non-empty Aggregate is rejected outright rather
+// than silently streaming un-aggregated events while persisting a Pos in the aggregate-resume
+// format (see Cursor.commit). Use Query for an aggregation-mode request instead.
+func (e *Executor) Follow(ctx context.Context, req *api.QueryRequest, ch chan<- *api.QueryResult) error {
+	if req.Aggregate != "" {
+		return errors.New("Follow does not support aggregation mode; use Query with Aggregate set instead")
+	}
+
+	state, err := stateFromRequest(req)
+	if err != nil {
+		return err
+	}
+
+	cur, err := newCursor(ctx, state, e.tidx, e.jctrl)
+	if err != nil {
+		return err
+	}
+
+	return cur.Follow(ctx, *req, ch)
+}
+
+// Sources implements api.Querier.
+func (e *Executor) Sources(ctx context.Context, tagsCond string, res *api.SourcesResult) error {
+	se, err := lql.ParseExpr(tagsCond)
+	if err != nil {
+		res.Err = errors.Wrapf(err, "Could not parse expression %q", tagsCond)
+		return nil
+	}
+
+	srcs, _, err := e.tidx.GetJournals(se, math.MaxInt32, false)
+	if err != nil {
+		res.Err = errors.Wrapf(err, "Could not get a list of journals for the expression %q", tagsCond)
+		return nil
+	}
+
+	res.Sources = make([]api.Source, 0, len(srcs))
+	for tags, src := range srcs {
+		jrnl, err := e.jctrl.GetOrCreate(ctx, src)
+		if err != nil {
+			res.Err = errors.Wrapf(err, "Could not get the access to the journal %s for tags %s", src, tags)
+			return nil
+		}
+
+		res.Sources = append(res.Sources, api.Source{Tags: string(tags), Size: jrnl.Size(), Records: jrnl.Count()})
+	}
+	res.Count = len(res.Sources)
+	return nil
+}
+
+// stateFromRequest turns a QueryRequest's single LQL line into the (Sources, Where) pair
+// cursor.State needs. The split is done by lql.ParseQuery, the same full-grammar parser already
+// assumed elsewhere in this package (e.g. lql.ParseExpr for the source-selector-only grammar).
+func stateFromRequest(req *api.QueryRequest) (State, error) {
+	src, where, err := lql.ParseQuery(req.Query)
+	if err != nil {
+		return State{}, errors.Wrapf(err, "Could not parse query %q", req.Query)
+	}
+
+	return State{
+		Id:        req.ReqId,
+		Sources:   src,
+		Where:     where,
+		Pos:       req.Pos,
+		Aggregate: req.Aggregate,
+	}, nil
+}
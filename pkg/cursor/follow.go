@@ -0,0 +1,177 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/logrange/logrange/api"
+	"github.com/pkg/errors"
+)
+
+// cHeartbeatInterval is how often Follow sends a HeartBeat frame while idle, so a caller can
+// detect a broken connection without waiting for the next real event.
+const cHeartbeatInterval = 15 * time.Second
+
+// cFollowPollInterval bounds how long Follow ever blocks before checking its sources for new data
+// again. journal.Journal exposes no change-notification API for this package to wake on instead,
+// so Follow polls at this fixed interval rather than blocking indefinitely between reads.
+const cFollowPollInterval = 200 * time.Millisecond
+
+// cFollowMaxBatch and cFollowFlushInterval bound how many events Follow accumulates before
+// sending them to ch. Without this, a Follow started from a Pos with a large backlog would read
+// straight through to the live tail before ever sending a single QueryResult, buffering the
+// entire backlog in memory - the opposite of the streaming, back-pressured behaviour Follow is
+// supposed to provide.
+const cFollowMaxBatch = 256
+const cFollowFlushInterval = 250 * time.Millisecond
+
+// Follow is the cursor-level implementation behind api.Querier.Follow: it holds the cursor open
+// and pushes api.QueryResult frames to ch as matching records arrive (or are caught up on, if Pos
+// started behind the tail), instead of requiring the caller to poll with repeated Query calls.
+// baseReq is echoed back as QueryResult.NextQueryRequest with Pos replaced by the cursor's
+// position after each frame, so the caller can always resume with a plain Query.
+//
+// Follow accumulates at most cFollowMaxBatch events, or cFollowFlushInterval worth of events,
+// before sending a frame - so reading a large backlog streams it in bounded chunks rather than
+// buffering all of it before the first send. Sends to ch additionally honor back-pressure: a send
+// blocks (subject to ctx) rather than growing an internal queue, so a slow consumer throttles the
+// read instead of growing memory without bound.
+//
+// Follow blocks until ctx is cancelled or an unrecoverable error occurs. On a clean ctx
+// cancellation it makes a best-effort attempt to deliver one last frame with the final committed
+// Pos and returns nil; on any other error it returns the error.
+//
+// Follow has no aggregate-aware path: it only ever reads raw events off cur.it and batches them as
+// api.LogEvent. A cursor created in aggregation mode (cur.agg != nil) is rejected outright, since
+// streaming un-aggregated events while cur.commit persists a Pos in the aggregate-resume format
+// (see Cursor.commit) would silently corrupt the resume position. Callers should route
+// Aggregate-bearing requests to Query instead (see Executor.Follow).
+func (cur *Cursor) Follow(ctx context.Context, baseReq api.QueryRequest, ch chan<- *api.QueryResult) error {
+	if cur.agg != nil {
+		return errors.New("Follow does not support aggregation mode")
+	}
+
+	hbTimer := time.NewTimer(cHeartbeatInterval)
+	defer hbTimer.Stop()
+	flushTimer := time.NewTimer(cFollowFlushInterval)
+	defer flushTimer.Stop()
+
+	wake := cur.newPollCh(ctx)
+
+	var batch []*api.LogEvent
+	lastFlush := time.Now()
+
+	flush := func(heartBeat bool) error {
+		pos := cur.commit(ctx).Pos
+		res := &api.QueryResult{Events: batch, HeartBeat: heartBeat}
+		res.NextQueryRequest = baseReq
+		res.NextQueryRequest.Pos = pos
+
+		if err := sendResult(ctx, ch, res); err != nil {
+			return err
+		}
+		batch = nil
+		lastFlush = time.Now()
+		hbTimer.Reset(cHeartbeatInterval)
+		flushTimer.Reset(cFollowFlushInterval)
+		return nil
+	}
+
+	for {
+		le, tags, err := cur.Get(ctx)
+		if err == nil {
+			batch = append(batch, toAPILogEvent(le, tags))
+			cur.Next(ctx)
+
+			if len(batch) >= cFollowMaxBatch || time.Since(lastFlush) >= cFollowFlushInterval {
+				if err := flush(false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err != io.EOF {
+			cur.commit(ctx)
+			return err
+		}
+
+		if len(batch) > 0 {
+			if err := flush(false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			pos := cur.commit(ctx).Pos
+			res := &api.QueryResult{NextQueryRequest: baseReq}
+			res.NextQueryRequest.Pos = pos
+			// best effort: ctx is already done, so a consumer which stopped reading at the same
+			// time must not make Follow hang on its way out.
+			select {
+			case ch <- res:
+			default:
+			}
+			return nil
+		case <-wake:
+		case <-flushTimer.C:
+			flushTimer.Reset(cFollowFlushInterval)
+		case <-hbTimer.C:
+			if err := flush(true); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendResult delivers res to ch, honoring ctx cancellation so Follow never blocks forever on a
+// consumer which stopped reading.
+func sendResult(ctx context.Context, ch chan<- *api.QueryResult, res *api.QueryResult) error {
+	select {
+	case ch <- res:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newPollCh returns a channel woken once per cFollowPollInterval, so Follow's select loop checks
+// its sources for new data on a fixed cadence instead of blocking indefinitely. This is the only
+// wake-up mechanism Follow has: journal.Journal exposes no change-notification API this package
+// could instead block on.
+func (cur *Cursor) newPollCh(ctx context.Context) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	go func() {
+		t := time.NewTicker(cFollowPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				select {
+				case out <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,88 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"context"
+
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+)
+
+// whereFilterIterator wraps the merged event stream and only lets through records matching a
+// WherePredicate - both the fields.* conditions (via fields.Registry) and the built-in ts/msg
+// ones. This is what actually runs lql.CompileWhere's result against every record, wiring it into
+// the read path instead of leaving it reachable only from its own package.
+type whereFilterIterator struct {
+	it   model.Iterator
+	pred *lql.WherePredicate
+	reg  *fields.Registry
+}
+
+// newWhereFilterIterator wraps it with pred, using reg to parse records which arrive with no
+// fields attached yet (e.g. the ingestion side hasn't been configured with a fields.Parser for
+// their tag). Returns it unchanged if pred is nil.
+func newWhereFilterIterator(it model.Iterator, pred *lql.WherePredicate, reg *fields.Registry) model.Iterator {
+	if pred == nil {
+		return it
+	}
+	return &whereFilterIterator{it: it, pred: pred, reg: reg}
+}
+
+// Next advances past the record last returned by Get.
+func (f *whereFilterIterator) Next(ctx context.Context) {
+	f.it.Next(ctx)
+}
+
+// Get returns the next record matching pred, skipping over (and advancing past) every record
+// which doesn't, until one does or the underlying iterator is exhausted.
+func (f *whereFilterIterator) Get(ctx context.Context) (model.LogEvent, model.TagLine, error) {
+	for {
+		le, tags, err := f.it.Get(ctx)
+		if err != nil {
+			return le, tags, err
+		}
+
+		if f.pred.Eval(resolveFields(f.reg, le, tags), le.Timestamp(), le.Msg()) {
+			return le, tags, nil
+		}
+
+		f.it.Next(ctx)
+	}
+}
+
+// resolveFields returns le's structured fields, parsed by the ingestion pipeline (pkg/fields) if
+// it already attached them, or parsed here on the fly via reg as a fallback for sources which
+// were never configured with a Parser at ingestion time. Every read path which evaluates
+// fields.* conditions (the Where filter here, and aggregate.go's histogram()/topk()/toAPILogEvent
+// in convert.go) must go through this instead of calling le.Fields() directly, or a record from a
+// source with no configured ingestion-time parser would silently see no fields at all.
+func resolveFields(reg *fields.Registry, le model.LogEvent, tags model.TagLine) fields.Fields {
+	if fl := le.Fields(); fl != nil {
+		return fl
+	}
+
+	p, ok := reg.ParserFor(string(tags))
+	if !ok {
+		return nil
+	}
+
+	fl, ok := p.Parse(le.Msg())
+	if !ok {
+		return nil
+	}
+	return fl
+}
@@ -0,0 +1,99 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fields parses structured (e.g. JSON) log records into a typed field map which is
+// attached to the record alongside its raw message, so pkg/lql predicates can be compiled over
+// the parsed fields instead of the raw text. The parser is pluggable per source tag: Registry
+// picks the Parser to run, and records which fail to parse (or whose tag has no Parser
+// configured) keep Fields == nil, so "=" predicates fall back to matching the raw message.
+package fields
+
+import "strconv"
+
+type (
+	// Kind identifies the Go type a Value holds.
+	Kind int
+
+	// Value is a single parsed field value. Only the member matching Kind is valid.
+	Value struct {
+		Kind Kind
+		Str  string
+		Num  float64
+		Bool bool
+	}
+
+	// Fields is a flattened, typed view of a structured record. Nested objects are flattened with
+	// "." separated keys, e.g. {"request":{"method":"POST"}} becomes Fields{"request.method": ...}.
+	Fields map[string]Value
+
+	// Parser turns a raw record message into Fields. It returns ok=false when msg could not be
+	// parsed as the expected format, in which case the caller should keep evaluating against the
+	// raw message.
+	Parser interface {
+		// Parse parses msg and returns the flattened field map, or ok=false if msg isn't valid
+		// input for this Parser.
+		Parse(msg []byte) (f Fields, ok bool)
+	}
+)
+
+const (
+	// KindString marks Value.Str as holding the value.
+	KindString Kind = iota
+	// KindNumber marks Value.Num as holding the value.
+	KindNumber
+	// KindBool marks Value.Bool as holding the value.
+	KindBool
+)
+
+// String returns the value formatted as a string, regardless of its Kind, so callers comparing
+// against an LQL string literal don't need to switch on Kind themselves.
+func (v Value) String() string {
+	switch v.Kind {
+	case KindNumber:
+		return strconv.FormatFloat(v.Num, 'f', -1, 64)
+	case KindBool:
+		return strconv.FormatBool(v.Bool)
+	default:
+		return v.Str
+	}
+}
+
+// ToStringMap converts f to a plain map[string]string, e.g. for populating api.LogEvent.Fields
+// over the wire. Returns nil for a nil Fields.
+func (f Fields) ToStringMap() map[string]string {
+	if f == nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(f))
+	for k, v := range f {
+		m[k] = v.String()
+	}
+	return m
+}
+
+// StringValue creates a Value holding a string.
+func StringValue(s string) Value {
+	return Value{Kind: KindString, Str: s}
+}
+
+// NumberValue creates a Value holding a float64.
+func NumberValue(n float64) Value {
+	return Value{Kind: KindNumber, Num: n}
+}
+
+// BoolValue creates a Value holding a bool.
+func BoolValue(b bool) Value {
+	return Value{Kind: KindBool, Bool: b}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fields
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JSONParser parses structured JSON log lines, such as Caddy's access log format, into Fields.
+// Nested objects are flattened with "." separated keys (request.method, request.uri, ...) and
+// the "ts" field, when present, is additionally parsed as an RFC3339 (ISO-8601) timestamp.
+type JSONParser struct{}
+
+// Parse implements Parser.
+func (p JSONParser) Parse(msg []byte) (Fields, bool) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return nil, false
+	}
+
+	f := make(Fields, len(raw))
+	flatten("", raw, f)
+	return f, true
+}
+
+// flatten writes every leaf of m into f, joining nested keys with "." and prefix.
+func flatten(prefix string, m map[string]interface{}, f Fields) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			flatten(key, vv, f)
+		case string:
+			if key == "ts" {
+				if t, err := time.Parse(time.RFC3339, vv); err == nil {
+					f[key] = NumberValue(float64(t.UnixNano()))
+					continue
+				}
+			}
+			f[key] = StringValue(vv)
+		case float64:
+			f[key] = NumberValue(vv)
+		case bool:
+			f[key] = BoolValue(vv)
+		default:
+			// nested arrays (e.g. resp_headers values) and null are kept as their JSON
+			// representation, so a predicate can still match them as a string.
+			if b, err := json.Marshal(vv); err == nil {
+				f[key] = StringValue(string(b))
+			}
+		}
+	}
+}
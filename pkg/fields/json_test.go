@@ -0,0 +1,65 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fields
+
+import "testing"
+
+func TestJSONParserParse(t *testing.T) {
+	msg := []byte(`{
+		"ts": "2019-05-06T10:20:30Z",
+		"status": 500,
+		"request": {"method": "POST", "uri": "/x"},
+		"resp_headers": {"Content-Type": ["text/plain"]},
+		"ok": true
+	}`)
+
+	f, ok := JSONParser{}.Parse(msg)
+	if !ok {
+		t.Fatal("expected the record to parse as JSON")
+	}
+
+	if v, ok := f["request.method"]; !ok || v.Kind != KindString || v.Str != "POST" {
+		t.Fatalf("expected flattened request.method=POST, got %+v, ok=%v", v, ok)
+	}
+	if v, ok := f["status"]; !ok || v.Kind != KindNumber || v.Num != 500 {
+		t.Fatalf("expected status=500 (number), got %+v, ok=%v", v, ok)
+	}
+	if v, ok := f["ok"]; !ok || v.Kind != KindBool || v.Bool != true {
+		t.Fatalf("expected ok=true (bool), got %+v, ok=%v", v, ok)
+	}
+	if v, ok := f["ts"]; !ok || v.Kind != KindNumber {
+		t.Fatalf("expected ts to parse as an RFC3339 timestamp (number), got %+v, ok=%v", v, ok)
+	}
+	if _, ok := f["resp_headers.Content-Type"]; !ok {
+		t.Fatal("expected a nested array value to still be present, kept as its JSON form")
+	}
+}
+
+func TestJSONParserParseInvalid(t *testing.T) {
+	if _, ok := JSONParser{}.Parse([]byte("not json")); ok {
+		t.Fatal("expected ok=false for a non-JSON message")
+	}
+}
+
+func TestJSONParserTsFallsBackToStringWhenNotRFC3339(t *testing.T) {
+	f, ok := JSONParser{}.Parse([]byte(`{"ts": "not-a-timestamp"}`))
+	if !ok {
+		t.Fatal("expected the record to still parse as JSON")
+	}
+	v, ok := f["ts"]
+	if !ok || v.Kind != KindString || v.Str != "not-a-timestamp" {
+		t.Fatalf("expected ts to fall back to a string value, got %+v, ok=%v", v, ok)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fields
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// Config describes which Parser a source tag should be parsed with. It is intended to be
+	// attached to the per-source ingestion configuration, so e.g. a tag of {app=caddy} can be
+	// parsed as JSON while everything else stays raw text.
+	Config struct {
+		// Tag is the source tag this config applies to, matched exactly against the record tag line.
+		Tag string
+		// Format names a registered Parser, e.g. "json". Empty disables structured parsing for Tag.
+		Format string
+	}
+
+	// Registry selects the Parser to run for a given source tag.
+	Registry struct {
+		lock   sync.RWMutex
+		byName map[string]Parser
+		byTag  map[string]Parser
+	}
+)
+
+// DefaultRegistry is the Registry consulted at read time by pkg/cursor (via its resolveFields)
+// for records whose source has no parsed fields attached yet. Ingestion pipelines should call
+// DefaultRegistry.Configure for every source tag that needs structured parsing.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates a Registry pre-populated with the built-in "json" parser. Parsers for
+// other formats (logfmt, CEE, GELF, ...) can be added via Register as they're implemented.
+func NewRegistry() *Registry {
+	r := &Registry{
+		byName: make(map[string]Parser),
+		byTag:  make(map[string]Parser),
+	}
+	r.Register("json", JSONParser{})
+	return r
+}
+
+// Register adds or replaces the Parser available under name, e.g. "json".
+func (r *Registry) Register(name string, p Parser) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.byName[name] = p
+}
+
+// Configure binds the Parser registered under cfg.Format to cfg.Tag, so ParserFor(cfg.Tag) finds
+// it afterwards. It returns an error if cfg.Format isn't a registered Parser.
+func (r *Registry) Configure(cfg Config) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.byName[cfg.Format]
+	if !ok {
+		return errors.Errorf("no parser registered for format %q", cfg.Format)
+	}
+	r.byTag[cfg.Tag] = p
+	return nil
+}
+
+// ParserFor returns the Parser configured for tag, and ok=false if none was configured - in which
+// case the record should be left unparsed.
+func (r *Registry) ParserFor(tag string) (Parser, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	p, ok := r.byTag[tag]
+	return p, ok
+}
@@ -0,0 +1,147 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lql
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/pkg/errors"
+)
+
+type (
+	// FieldOp is a comparison operator a FieldPredicate applies between a record's parsed field
+	// value and the literal from the Where clause.
+	FieldOp int
+
+	// FieldPath is a compiled "fields.a.b.c" reference from a Where clause. Compiling it once and
+	// reusing it across every record in a cursor avoids re-splitting the same path string per record.
+	FieldPath struct {
+		raw string
+		key string
+	}
+
+	// FieldPredicate is a single "fields.<path> <op> <literal>" condition parsed out of a Cursor's
+	// Where clause, e.g. fields.status >= 500 or fields.request.method = "POST". When a record has
+	// no parsed fields (the source has no Parser configured, or parsing failed), Eval falls back to
+	// a substring match of the literal against the raw message for FieldOpEq; every other operator
+	// does not match such a record.
+	FieldPredicate struct {
+		path FieldPath
+		op   FieldOp
+		val  fields.Value
+	}
+)
+
+const (
+	// FieldOpEq matches equal values.
+	FieldOpEq FieldOp = iota
+	// FieldOpNe matches unequal values.
+	FieldOpNe
+	// FieldOpLt matches field < literal (numeric only).
+	FieldOpLt
+	// FieldOpLe matches field <= literal (numeric only).
+	FieldOpLe
+	// FieldOpGt matches field > literal (numeric only).
+	FieldOpGt
+	// FieldOpGe matches field >= literal (numeric only).
+	FieldOpGe
+)
+
+// FieldsPrefix is the Where clause prefix which introduces a predicate over parsed structured
+// fields, as opposed to the built-in ts/msg predicates: "fields.status >= 500".
+const FieldsPrefix = "fields."
+
+var pathCache sync.Map // string (raw "fields.a.b") -> FieldPath
+
+// Key returns the flattened fields.Fields key this path resolves to, e.g. "request.method" for
+// "fields.request.method".
+func (p FieldPath) Key() string {
+	return p.key
+}
+
+// CompileFieldPath compiles a dotted "fields.a.b.c" reference into the flattened key used to
+// index fields.Fields ("a.b.c"), caching the result so repeated predicates over the same path
+// (the common case - the same Where clause evaluated against every record in a cursor) don't
+// re-split the string each time.
+func CompileFieldPath(raw string) (FieldPath, error) {
+	if v, ok := pathCache.Load(raw); ok {
+		return v.(FieldPath), nil
+	}
+
+	if !strings.HasPrefix(raw, FieldsPrefix) {
+		return FieldPath{}, errors.Errorf("field reference %q must start with %q", raw, FieldsPrefix)
+	}
+
+	key := strings.TrimPrefix(raw, FieldsPrefix)
+	if key == "" {
+		return FieldPath{}, errors.Errorf("field reference %q has no path after %q", raw, FieldsPrefix)
+	}
+
+	fp := FieldPath{raw: raw, key: key}
+	pathCache.Store(raw, fp)
+	return fp, nil
+}
+
+// CompileFieldPredicate builds a FieldPredicate for path op literal, e.g.
+// CompileFieldPredicate("fields.status", FieldOpGe, "500").
+func CompileFieldPredicate(path string, op FieldOp, literal string) (*FieldPredicate, error) {
+	fp, err := CompileFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	val := fields.StringValue(literal)
+	if n, err := strconv.ParseFloat(literal, 64); err == nil {
+		val = fields.NumberValue(n)
+	} else if b, err := strconv.ParseBool(literal); err == nil {
+		val = fields.BoolValue(b)
+	}
+
+	return &FieldPredicate{path: fp, op: op, val: val}, nil
+}
+
+// Eval reports whether the record matches the predicate. f is the record's parsed fields (nil if
+// it couldn't be parsed); raw is always the original message, used as a fallback in that case.
+func (p *FieldPredicate) Eval(f fields.Fields, raw []byte) bool {
+	if f == nil {
+		return p.op == FieldOpEq && bytes.Contains(raw, []byte(p.val.String()))
+	}
+
+	v, ok := f[p.path.key]
+	if !ok {
+		return false
+	}
+
+	switch p.op {
+	case FieldOpEq:
+		return v.String() == p.val.String()
+	case FieldOpNe:
+		return v.String() != p.val.String()
+	case FieldOpLt:
+		return v.Kind == fields.KindNumber && v.Num < p.val.Num
+	case FieldOpLe:
+		return v.Kind == fields.KindNumber && v.Num <= p.val.Num
+	case FieldOpGt:
+		return v.Kind == fields.KindNumber && v.Num > p.val.Num
+	case FieldOpGe:
+		return v.Kind == fields.KindNumber && v.Num >= p.val.Num
+	default:
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lql
+
+import (
+	"testing"
+
+	"github.com/logrange/logrange/pkg/fields"
+)
+
+func TestCompileFieldPath(t *testing.T) {
+	fp, err := CompileFieldPath("fields.request.method")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fp.Key() != "request.method" {
+		t.Fatalf("expected key %q, got %q", "request.method", fp.Key())
+	}
+
+	if _, err := CompileFieldPath("status"); err == nil {
+		t.Fatal("expected an error for a path with no \"fields.\" prefix")
+	}
+
+	if _, err := CompileFieldPath("fields."); err == nil {
+		t.Fatal("expected an error for a path with nothing after \"fields.\"")
+	}
+}
+
+func TestFieldPredicateEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		op      FieldOp
+		literal string
+		f       fields.Fields
+		raw     string
+		want    bool
+	}{
+		{
+			name:    "numeric >=, matches",
+			path:    "fields.status",
+			op:      FieldOpGe,
+			literal: "500",
+			f:       fields.Fields{"status": fields.NumberValue(503)},
+			want:    true,
+		},
+		{
+			name:    "numeric >=, below threshold",
+			path:    "fields.status",
+			op:      FieldOpGe,
+			literal: "500",
+			f:       fields.Fields{"status": fields.NumberValue(200)},
+			want:    false,
+		},
+		{
+			name:    "string equality",
+			path:    "fields.request.method",
+			op:      FieldOpEq,
+			literal: "POST",
+			f:       fields.Fields{"request.method": fields.StringValue("POST")},
+			want:    true,
+		},
+		{
+			name:    "missing key never matches",
+			path:    "fields.status",
+			op:      FieldOpEq,
+			literal: "500",
+			f:       fields.Fields{},
+			want:    false,
+		},
+		{
+			name:    "nil fields falls back to a raw message substring match, op =",
+			path:    "fields.status",
+			op:      FieldOpEq,
+			literal: "500",
+			f:       nil,
+			raw:     "request failed with status 500",
+			want:    true,
+		},
+		{
+			name:    "nil fields, non-= op never matches",
+			path:    "fields.status",
+			op:      FieldOpGe,
+			literal: "500",
+			f:       nil,
+			raw:     "request failed with status 500",
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pred, err := CompileFieldPredicate(tt.path, tt.op, tt.literal)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := pred.Eval(tt.f, []byte(tt.raw)); got != tt.want {
+				t.Fatalf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
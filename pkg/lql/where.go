@@ -0,0 +1,418 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lql
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logrange/logrange/pkg/fields"
+	"github.com/pkg/errors"
+)
+
+// WherePredicate is a compiled Cursor.State.Where clause: a boolean expression of "fields.<path>
+// <op> <literal>" conditions (compiled via CompileFieldPredicate) and built-in "ts <op> <literal>"
+// / "msg CONTAINS <literal>" conditions, combined with AND, OR and parentheses. It is the only
+// Where evaluator this package has, so it is the one the read path (pkg/cursor) must run against
+// every record of the merged stream: nothing else filters on ts/msg.
+type WherePredicate struct {
+	root predNode
+}
+
+// Eval reports whether the record matches the compiled clause, both the fields.* conditions
+// (against f) and the built-in ts/msg ones (against ts and raw).
+func (w *WherePredicate) Eval(f fields.Fields, ts int64, raw []byte) bool {
+	if w.root == nil {
+		return true
+	}
+	return w.root.eval(f, ts, raw)
+}
+
+// predNode is one node of a compiled Where expression tree: either a leaf condition or an
+// AND/OR combination of two subtrees.
+type predNode interface {
+	eval(f fields.Fields, ts int64, raw []byte) bool
+}
+
+type andNode struct{ left, right predNode }
+
+func (n *andNode) eval(f fields.Fields, ts int64, raw []byte) bool {
+	return n.left.eval(f, ts, raw) && n.right.eval(f, ts, raw)
+}
+
+type orNode struct{ left, right predNode }
+
+func (n *orNode) eval(f fields.Fields, ts int64, raw []byte) bool {
+	return n.left.eval(f, ts, raw) || n.right.eval(f, ts, raw)
+}
+
+// fieldNode adapts a FieldPredicate (which only looks at f and raw) to predNode.
+type fieldNode struct{ pred *FieldPredicate }
+
+func (n *fieldNode) eval(f fields.Fields, ts int64, raw []byte) bool {
+	return n.pred.Eval(f, raw)
+}
+
+// baseNode is a single "ts <op> <literal>" or "msg CONTAINS <literal>" condition - the built-in
+// counterpart of FieldPredicate for the two properties every LogEvent has regardless of whether
+// it was parsed into structured fields.
+type baseNode struct {
+	isMsg bool
+	op    FieldOp // FieldOpContains, FieldOpEq or FieldOpNe for msg; any other FieldOp for ts
+	ts    int64
+	str   string
+}
+
+// FieldOpContains matches a msg condition: raw message contains the literal as a substring.
+const FieldOpContains FieldOp = -1
+
+func (n *baseNode) eval(f fields.Fields, ts int64, raw []byte) bool {
+	if n.isMsg {
+		switch n.op {
+		case FieldOpContains:
+			return bytes.Contains(raw, []byte(n.str))
+		case FieldOpEq:
+			return string(raw) == n.str
+		case FieldOpNe:
+			return string(raw) != n.str
+		default:
+			return false
+		}
+	}
+
+	switch n.op {
+	case FieldOpEq:
+		return ts == n.ts
+	case FieldOpNe:
+		return ts != n.ts
+	case FieldOpLt:
+		return ts < n.ts
+	case FieldOpLe:
+		return ts <= n.ts
+	case FieldOpGt:
+		return ts > n.ts
+	case FieldOpGe:
+		return ts >= n.ts
+	default:
+		return false
+	}
+}
+
+// CompileWhere compiles a Cursor.State.Where clause into a WherePredicate. Clauses support AND,
+// OR and parentheses (AND binds tighter than OR, same as most query languages); literals may be
+// quoted ("POST", "2019-05-06T10:20:30Z") or bare (500, true). Each condition is either a
+// "fields.<path> <op> <literal>" condition or a built-in "ts <op> <literal>" / "msg (=|!=|CONTAINS)
+// <literal>" condition - there is no other Where evaluator in this package, so a condition
+// matching neither is a parse error rather than being silently ignored. An empty where returns a
+// nil WherePredicate and a nil error, since there is nothing to compile.
+func CompileWhere(where string) (*WherePredicate, error) {
+	where = strings.TrimSpace(where)
+	if where == "" {
+		return nil, nil
+	}
+
+	toks, err := tokenizeWhere(where)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &whereParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, errors.Errorf("unexpected %q after the end of the clause", p.toks[p.pos].text)
+	}
+
+	return &WherePredicate{root: root}, nil
+}
+
+// whereParser is a recursive-descent parser over the tokens produced by tokenizeWhere:
+//
+//	or   := and (OR and)*
+//	and  := term (AND term)*
+//	term := '(' or ')' | condition
+type whereParser struct {
+	toks []whereToken
+	pos  int
+}
+
+func (p *whereParser) parseOr() (predNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKind() == tokOr {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (predNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKind() == tokAnd {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseTerm() (predNode, error) {
+	if p.peekKind() == tokLParen {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekKind() != tokRParen {
+			return nil, errors.New("missing closing ')'")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseCondition()
+}
+
+// parseCondition parses a single "<name> <op> <literal>" condition, where name is "fields.<path>",
+// "ts" or "msg".
+func (p *whereParser) parseCondition() (predNode, error) {
+	name, err := p.expect(tokWord)
+	if err != nil {
+		return nil, errors.Wrap(err, "expected a \"fields.<path>\", \"ts\" or \"msg\" condition")
+	}
+
+	opTok := p.cur()
+	if opTok.kind != tokOp && opTok.kind != tokContains {
+		return nil, errors.Errorf("expected an operator after %q, got %q", name.text, opTok.text)
+	}
+	p.pos++
+
+	lit := p.cur()
+	if lit.kind != tokWord && lit.kind != tokString {
+		return nil, errors.Errorf("expected a literal after %q %q", name.text, opTok.text)
+	}
+	p.pos++
+
+	opStr := opTok.text
+	if opTok.kind == tokContains {
+		opStr = "CONTAINS"
+	}
+
+	if strings.HasPrefix(strings.ToLower(name.text), FieldsPrefix) {
+		if opTok.kind == tokContains {
+			return nil, errors.Errorf("%q does not support CONTAINS", name.text)
+		}
+		op, err := parseFieldOp(opStr)
+		if err != nil {
+			return nil, err
+		}
+		pred, err := CompileFieldPredicate(name.text, op, lit.text)
+		if err != nil {
+			return nil, err
+		}
+		return &fieldNode{pred: pred}, nil
+	}
+
+	bn, err := compileBaseNode(strings.ToLower(name.text), opStr, lit.text)
+	if err != nil {
+		return nil, err
+	}
+	return bn, nil
+}
+
+func (p *whereParser) cur() whereToken {
+	if p.pos >= len(p.toks) {
+		return whereToken{kind: tokEOF}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *whereParser) peekKind() tokenKind {
+	return p.cur().kind
+}
+
+func (p *whereParser) expect(k tokenKind) (whereToken, error) {
+	t := p.cur()
+	if t.kind != k {
+		return whereToken{}, errors.Errorf("unexpected %q", t.text)
+	}
+	p.pos++
+	return t, nil
+}
+
+// compileBaseNode builds a baseNode for "name op literal", where name is "ts" or "msg".
+func compileBaseNode(name, opStr, literal string) (*baseNode, error) {
+	if name == "msg" {
+		if strings.EqualFold(opStr, "CONTAINS") {
+			return &baseNode{isMsg: true, op: FieldOpContains, str: literal}, nil
+		}
+		op, err := parseFieldOp(opStr)
+		if err != nil {
+			return nil, err
+		}
+		if op != FieldOpEq && op != FieldOpNe {
+			return nil, errors.Errorf("msg only supports =, != or CONTAINS, got %q", opStr)
+		}
+		return &baseNode{isMsg: true, op: op, str: literal}, nil
+	}
+
+	if name != "ts" {
+		return nil, errors.Errorf("unknown condition %q, expected \"fields.<path>\", \"ts\" or \"msg\"", name)
+	}
+	if strings.EqualFold(opStr, "CONTAINS") {
+		return nil, errors.New("ts does not support CONTAINS")
+	}
+	op, err := parseFieldOp(opStr)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := parseTsLiteral(literal)
+	if err != nil {
+		return nil, err
+	}
+	return &baseNode{isMsg: false, op: op, ts: ts}, nil
+}
+
+// parseTsLiteral parses a ts literal either as raw unix nanoseconds (123456) or, like
+// fields.JSONParser's "ts" handling, as an RFC3339 (ISO-8601) timestamp ("2019-05-06T10:20:30Z").
+func parseTsLiteral(literal string) (int64, error) {
+	if ns, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		return ns, nil
+	}
+	if t, err := time.Parse(time.RFC3339, literal); err == nil {
+		return t.UnixNano(), nil
+	}
+	return 0, errors.Errorf("could not parse %q as a ts value (unix nanoseconds or RFC3339)", literal)
+}
+
+func parseFieldOp(s string) (FieldOp, error) {
+	switch s {
+	case "=":
+		return FieldOpEq, nil
+	case "!=":
+		return FieldOpNe, nil
+	case "<":
+		return FieldOpLt, nil
+	case "<=":
+		return FieldOpLe, nil
+	case ">":
+		return FieldOpGt, nil
+	case ">=":
+		return FieldOpGe, nil
+	default:
+		return 0, errors.Errorf("unknown operator %q", s)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord           // a bare identifier or literal: fields.status, ts, msg, 500, true, POST
+	tokString         // a double-quoted literal, unescaped
+	tokOp             // = != < <= > >=
+	tokContains       // CONTAINS, case-insensitive
+	tokAnd            // AND, case-insensitive
+	tokOr             // OR, case-insensitive
+	tokLParen
+	tokRParen
+)
+
+type whereToken struct {
+	kind tokenKind
+	text string
+}
+
+// tokenizeWhere splits a Where clause into tokens, honoring double-quoted string literals so a
+// quote's contents (which may themselves contain "AND", "fields.", operators, ...) are never
+// mistaken for syntax.
+func tokenizeWhere(where string) ([]whereToken, error) {
+	var toks []whereToken
+	i, n := 0, len(where)
+
+	for i < n {
+		c := where[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, whereToken{kind: tokLParen, text: "("})
+			i++
+		case c == ')':
+			toks = append(toks, whereToken{kind: tokRParen, text: ")"})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < n && where[j] != '"' {
+				j++
+			}
+			if j >= n {
+				return nil, errors.Errorf("unterminated quoted literal starting at %q", where[i:])
+			}
+			toks = append(toks, whereToken{kind: tokString, text: where[i+1 : j]})
+			i = j + 1
+		case c == '>' || c == '<' || c == '=' || c == '!':
+			j := i + 1
+			if j < n && where[j] == '=' {
+				j++
+			}
+			toks = append(toks, whereToken{kind: tokOp, text: where[i:j]})
+			i = j
+		default:
+			j := i
+			for j < n && !strings.ContainsRune(" \t\n\r()\"=!<>", rune(where[j])) {
+				j++
+			}
+			if j == i {
+				return nil, errors.Errorf("unexpected character %q", string(c))
+			}
+			word := where[i:j]
+			toks = append(toks, wordToken(word))
+			i = j
+		}
+	}
+
+	return toks, nil
+}
+
+// wordToken classifies a bare (unquoted) word as one of the clause's keywords (AND, OR, CONTAINS)
+// or a plain tokWord (an identifier or bare literal).
+func wordToken(word string) whereToken {
+	switch strings.ToUpper(word) {
+	case "AND":
+		return whereToken{kind: tokAnd, text: word}
+	case "OR":
+		return whereToken{kind: tokOr, text: word}
+	case "CONTAINS":
+		return whereToken{kind: tokContains, text: word}
+	default:
+		return whereToken{kind: tokWord, text: word}
+	}
+}
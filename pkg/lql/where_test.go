@@ -0,0 +1,165 @@
+// Copyright 2018-2019 The logrange Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/logrange/logrange/pkg/fields"
+)
+
+// mustParseRFC3339 parses an RFC3339 literal to unix nanoseconds for building test expectations;
+// it panics on a malformed literal since every caller here passes a constant.
+func mustParseRFC3339(s string) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t.UnixNano()
+}
+
+func TestCompileWhereEmpty(t *testing.T) {
+	pred, err := CompileWhere("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pred != nil {
+		t.Fatalf("expected a nil predicate for an empty clause, got %+v", pred)
+	}
+}
+
+func TestCompileWhereEval(t *testing.T) {
+	tests := []struct {
+		name  string
+		where string
+		f     fields.Fields
+		ts    int64
+		raw   string
+		want  bool
+	}{
+		{
+			name:  "fields-only clause",
+			where: `fields.status >= 500`,
+			f:     fields.Fields{"status": fields.NumberValue(503)},
+			want:  true,
+		},
+		{
+			name:  "ts-only clause",
+			where: "ts > 100",
+			ts:    200,
+			want:  true,
+		},
+		{
+			name:  "msg CONTAINS",
+			where: `msg CONTAINS "panic"`,
+			raw:   "goroutine panic: nil pointer",
+			want:  true,
+		},
+		{
+			name:  "msg CONTAINS, no match",
+			where: `msg CONTAINS "panic"`,
+			raw:   "all good here",
+			want:  false,
+		},
+		{
+			name:  "mixed ts/msg and fields.*, all must match",
+			where: `ts > 0 AND fields.status >= 500 AND msg CONTAINS "err"`,
+			ts:    1,
+			f:     fields.Fields{"status": fields.NumberValue(500)},
+			raw:   "err: boom",
+			want:  true,
+		},
+		{
+			name:  "mixed clause, one condition fails",
+			where: `ts > 0 AND fields.status >= 500`,
+			ts:    1,
+			f:     fields.Fields{"status": fields.NumberValue(200)},
+			want:  false,
+		},
+		{
+			name:  "case-insensitive AND and CONTAINS",
+			where: `msg contains "x" and ts >= 0`,
+			ts:    0,
+			raw:   "y x z",
+			want:  true,
+		},
+		{
+			name:  "quoted literal containing AND is not split",
+			where: `msg CONTAINS "a AND b"`,
+			raw:   "saw a AND b happen",
+			want:  true,
+		},
+		{
+			name:  "quoted literal containing fields. is not misrouted to the field branch",
+			where: `msg CONTAINS "fields.x"`,
+			raw:   "value is fields.x here",
+			want:  true,
+		},
+		{
+			name:  "OR of two conditions",
+			where: `fields.status >= 500 OR msg CONTAINS "panic"`,
+			f:     fields.Fields{"status": fields.NumberValue(200)},
+			raw:   "goroutine panic: nil pointer",
+			want:  true,
+		},
+		{
+			name:  "parentheses override AND/OR precedence",
+			where: `ts > 0 AND (fields.status >= 500 OR msg CONTAINS "panic")`,
+			ts:    1,
+			f:     fields.Fields{"status": fields.NumberValue(200)},
+			raw:   "goroutine panic: nil pointer",
+			want:  true,
+		},
+		{
+			name:  "ts accepts an RFC3339 literal",
+			where: `ts >= "2019-05-06T10:20:30Z"`,
+			ts:    mustParseRFC3339("2019-05-06T10:20:30Z"),
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pred, err := CompileWhere(tt.where)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := pred.Eval(tt.f, tt.ts, []byte(tt.raw)); got != tt.want {
+				t.Fatalf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileWhereErrors(t *testing.T) {
+	tests := []string{
+		`nonsense clause`,
+		`fields.status ~= 500`,
+		`msg > "x"`,
+		`ts CONTAINS "x"`,
+		`ts > notanumber`,
+		`(fields.status >= 500`,
+		`fields.status >= 500)`,
+	}
+
+	for _, where := range tests {
+		t.Run(where, func(t *testing.T) {
+			if _, err := CompileWhere(where); err == nil {
+				t.Fatalf("expected an error for %q", where)
+			}
+		})
+	}
+}